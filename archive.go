@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ArchiveFormat identifies a supported archive/compression format.
+type ArchiveFormat int
+
+// Supported archive formats for Extract and DetectFormat.
+const (
+	FormatUnknown ArchiveFormat = iota
+	FormatTarGzip
+	FormatTarBzip2
+	FormatTarXz
+	FormatZip
+)
+
+var magicBytes = []struct {
+	format ArchiveFormat
+	magic  []byte
+}{
+	{FormatTarGzip, []byte{0x1f, 0x8b}},
+	{FormatTarBzip2, []byte{0x42, 0x5a, 0x68}},
+	{FormatTarXz, []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}},
+	{FormatZip, []byte{0x50, 0x4b, 0x03, 0x04}},
+}
+
+// DetectFormat peeks at the magic bytes of r to identify its archive
+// format, and returns a reader with those bytes rewound onto the front so
+// callers can still read the full stream from the start.
+func DetectFormat(r io.Reader) (ArchiveFormat, io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	maxLen := 0
+	for _, m := range magicBytes {
+		if len(m.magic) > maxLen {
+			maxLen = len(m.magic)
+		}
+	}
+
+	peeked, err := br.Peek(maxLen)
+	if err != nil && err != io.EOF {
+		return FormatUnknown, br, err
+	}
+
+	for _, m := range magicBytes {
+		if bytes.HasPrefix(peeked, m.magic) {
+			return m.format, br, nil
+		}
+	}
+	return FormatUnknown, br, fmt.Errorf("archive: unrecognized format")
+}
+
+// Extract unpacks r into dst according to format, applying the same
+// safe-path, symlink, and hardlink rules as SafeUntargzip regardless of the
+// underlying compression. opts may be nil, as with SafeUntargzip.
+func Extract(dst string, r io.Reader, format ArchiveFormat, opts *ExtractOptions) error {
+	switch format {
+	case FormatTarGzip:
+		return SafeUntargzip(dst, r, opts)
+	case FormatTarBzip2:
+		return safeUntarStream(dst, tar.NewReader(bzip2.NewReader(r)), opts)
+	case FormatTarXz:
+		xr, closeXr, err := xzDecompress(r)
+		if err != nil {
+			return err
+		}
+		defer closeXr()
+		return safeUntarStream(dst, tar.NewReader(xr), opts)
+	case FormatZip:
+		return extractZip(dst, r, opts)
+	default:
+		return fmt.Errorf("archive: unsupported format %d", format)
+	}
+}
+
+// zip requires random access, so buffer the body to a temp file when r
+// isn't already an *os.File. opts carries the same MaxSize/MaxFiles
+// limits SafeUntargzip enforces for tar-based formats.
+func extractZip(dst string, r io.Reader, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+
+	ra, size, cleanup, err := asReaderAt(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+
+	if opts.MaxFiles > 0 && len(zr.File) > opts.MaxFiles {
+		return fmt.Errorf("archive: zip exceeds MaxFiles (%d)", opts.MaxFiles)
+	}
+
+	var written int64
+	for _, f := range zr.File {
+		fpath, err := safeJoin(dst, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(fpath, f.Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			target, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := extractSymlink(dst, fpath, string(target)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		out, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		if err != nil {
+			rc.Close()
+			return err
+		}
+
+		src := io.Reader(rc)
+		if opts.MaxSize > 0 {
+			src = io.LimitReader(rc, opts.MaxSize-written+1)
+		}
+		n, err := io.Copy(out, src)
+		rc.Close()
+		out.Close()
+		if err != nil {
+			return err
+		}
+		written += n
+		if opts.MaxSize > 0 && written > opts.MaxSize {
+			return fmt.Errorf("archive: zip exceeds MaxSize (%d bytes)", opts.MaxSize)
+		}
+
+		if err := applyMetadata(fpath, &tar.Header{ModTime: f.Modified}, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xzDecompress decodes an xz stream by shelling out to the system `xz`
+// binary, since the standard library has no xz decoder and this repo
+// doesn't vendor third-party ones. The caller must call the returned close
+// func once done reading to release the subprocess.
+func xzDecompress(r io.Reader) (io.Reader, func() error, error) {
+	cmd := exec.Command("xz", "-d", "-c")
+	cmd.Stdin = r
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("archive: xz not available: %v", err)
+	}
+
+	closeFn := func() error {
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("archive: xz: %v: %s", err, stderr.String())
+		}
+		return nil
+	}
+	return stdout, closeFn, nil
+}
+
+// asReaderAt returns an io.ReaderAt over r's full contents. If r is an
+// *os.File, it is used directly; otherwise (including other io.ReaderAt
+// types) the contents are buffered to a temp file, which the caller must
+// remove via the returned cleanup func.
+func asReaderAt(r io.Reader) (io.ReaderAt, int64, func(), error) {
+	if ra, ok := r.(io.ReaderAt); ok {
+		if f, ok := r.(*os.File); ok {
+			fi, err := f.Stat()
+			if err != nil {
+				return nil, 0, func() {}, err
+			}
+			return ra, fi.Size(), func() {}, nil
+		}
+	}
+
+	tmp, err := ioutil.TempFile("", "wercker-archive-")
+	if err != nil {
+		return nil, 0, func() {}, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		cleanup()
+		return nil, 0, func() {}, err
+	}
+	return tmp, n, cleanup, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// compress/bzip2 in the standard library only provides a reader, not a
+// writer, so there's no stdlib way to produce a bzip2 fixture here. Instead
+// these tests exercise safeUntarStream directly with a nil *ExtractOptions,
+// which is exactly the path Extract's FormatTarBzip2/FormatTarXz branches
+// hit, to cover the nil-pointer-dereference regression without depending on
+// an external bzip2/xz encoder.
+func newTarWithFile(t *testing.T, name, body string) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestSafeUntarStreamNilOpts(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := newTarWithFile(t, "hello.txt", "hi")
+	if err := safeUntarStream(dst, tar.NewReader(buf), nil); err != nil {
+		t.Fatalf("safeUntarStream with nil opts should not panic or error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hi" {
+		t.Fatalf("got %q, want %q", data, "hi")
+	}
+}
+
+func TestExtractUnsupportedFormat(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	err = Extract(dst, bytes.NewReader(nil), FormatUnknown, nil)
+	if err == nil {
+		t.Fatal("expected an error for FormatUnknown")
+	}
+}
+
+func newZipWithFiles(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// TestExtractZipAppliesMaxFiles guards against Extract/extractZip silently
+// dropping opts on the floor: a caller passing MaxFiles should get the same
+// enforcement SafeUntargzip already gives tar-based formats.
+func TestExtractZipAppliesMaxFiles(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := newZipWithFiles(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	if err := Extract(dst, buf, FormatZip, &ExtractOptions{MaxFiles: 1}); err == nil {
+		t.Fatal("expected an error when the zip exceeds MaxFiles")
+	}
+}
+
+// TestExtractZipAppliesMaxSize mirrors TestExtractZipAppliesMaxFiles for the
+// MaxSize limit.
+func TestExtractZipAppliesMaxSize(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := newZipWithFiles(t, map[string]string{"big.txt": "hello world"})
+	if err := Extract(dst, buf, FormatZip, &ExtractOptions{MaxSize: 4}); err == nil {
+		t.Fatal("expected an error when the zip exceeds MaxSize")
+	}
+}
+
+func TestDetectFormatZip(t *testing.T) {
+	magic := []byte{0x50, 0x4b, 0x03, 0x04, 0x00, 0x00}
+	format, r, err := DetectFormat(bytes.NewReader(magic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatZip {
+		t.Fatalf("got format %v, want FormatZip", format)
+	}
+
+	rewound, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rewound, magic) {
+		t.Fatalf("DetectFormat did not rewind the magic bytes onto r")
+	}
+}
+
+func newZipWithSymlink(t *testing.T, linkName, target string) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	hdr := &zip.FileHeader{Name: linkName}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(target)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestExtractZipSymlink(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := newZipWithSymlink(t, "link", "target.txt")
+	if err := Extract(dst, buf, FormatZip, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.Readlink(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "target.txt" {
+		t.Fatalf("got symlink target %q, want %q", got, "target.txt")
+	}
+}
+
+func TestExtractZipSymlinkEscape(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := newZipWithSymlink(t, "link", "../../etc/passwd")
+	if err := Extract(dst, buf, FormatZip, nil); err == nil {
+		t.Fatal("expected an error for a symlink target escaping dst")
+	}
+}
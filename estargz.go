@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// estargzFooterSize is the fixed size of the gzip-compliant footer eStargz
+// appends to the end of the archive. The footer is itself a valid empty
+// gzip stream whose extra field encodes the byte offset of the TOC.
+const estargzFooterSize = 51
+
+// estargzTOCEntry mirrors one entry of the JSON TOC appended to an eStargz
+// archive, as produced by containerd/stargz-snapshotter.
+type estargzTOCEntry struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Offset      int64  `json:"offset"`
+	Size        int64  `json:"size"`
+	ChunkOffset int64  `json:"chunkOffset"`
+	ChunkSize   int64  `json:"chunkSize"`
+	Digest      string `json:"digest"`
+}
+
+type estargzTOC struct {
+	Version int               `json:"version"`
+	Entries []estargzTOCEntry `json:"entries"`
+}
+
+// UntarOneAt writes the contents of the single file name to dst, reading
+// from the seekable tar.gz archive ra of the given size.
+//
+// If ra is an eStargz-formatted archive (each file compressed as its own
+// independent gzip stream, with a JSON TOC appended after the payload),
+// the TOC is used to seek directly to the entry and stream out just its
+// bytes. Otherwise this falls back to the linear scan done by untarOne.
+func UntarOneAt(name string, dst io.Writer, ra io.ReaderAt, size int64) error {
+	toc, err := readEstargzTOC(ra, size)
+	if err != nil || toc == nil {
+		return untarOneAt(name, dst, ra, size)
+	}
+
+	for _, entry := range toc.Entries {
+		if entry.Name != name || entry.Type != "reg" {
+			continue
+		}
+		section := io.NewSectionReader(ra, entry.Offset, size-entry.Offset)
+		gz, err := gzip.NewReader(section)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		_, err = io.CopyN(dst, gz, entry.Size)
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	return fmt.Errorf("untarOneAt: %q not found in TOC", name)
+}
+
+// readEstargzTOC reads the trailing footer of an eStargz archive to find
+// and parse the JSON TOC. It returns a nil toc (with no error) when the
+// archive doesn't end in a recognizable eStargz footer, so callers can
+// fall back to a plain linear scan.
+func readEstargzTOC(ra io.ReaderAt, size int64) (*estargzTOC, error) {
+	if size < estargzFooterSize {
+		return nil, nil
+	}
+
+	footer := make([]byte, estargzFooterSize)
+	if _, err := ra.ReadAt(footer, size-estargzFooterSize); err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(footer))
+	if err != nil {
+		// Not a gzip footer at all, so definitely not eStargz.
+		return nil, nil
+	}
+	defer gz.Close()
+	// Drain the (empty) payload to populate the gzip extra field.
+	io.Copy(io.Discard, gz)
+
+	tocOffset, ok := parseEstargzExtra(gz.Header.Extra)
+	if !ok {
+		return nil, nil
+	}
+
+	tocSection := io.NewSectionReader(ra, tocOffset, size-estargzFooterSize-tocOffset)
+	tocGz, err := gzip.NewReader(tocSection)
+	if err != nil {
+		return nil, nil
+	}
+	defer tocGz.Close()
+
+	var toc estargzTOC
+	if err := json.NewDecoder(tocGz).Decode(&toc); err != nil {
+		return nil, nil
+	}
+	return &toc, nil
+}
+
+// parseEstargzExtra extracts the TOC offset from the gzip extra field
+// eStargz writes into its footer. Per RFC 1952 section 2.3.1.1, the extra
+// field is a subfield with a 2-byte ID ("SG"), a little-endian 2-byte
+// length, and a payload of 16 hex digits giving the TOC offset immediately
+// followed by the literal "STARGZ" (e.g. "0000000000001a2bSTARGZ").
+func parseEstargzExtra(extra []byte) (int64, bool) {
+	if len(extra) < 4 || extra[0] != 'S' || extra[1] != 'G' {
+		return 0, false
+	}
+	subLen := int(binary.LittleEndian.Uint16(extra[2:4]))
+	if len(extra) < 4+subLen {
+		return 0, false
+	}
+	payload := extra[4 : 4+subLen]
+
+	const suffix = "STARGZ"
+	if len(payload) < 16+len(suffix) || string(payload[len(payload)-len(suffix):]) != suffix {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(string(payload[:len(payload)-len(suffix)]), 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return offset, true
+}
+
+// untarOneAt is the io.ReaderAt-based equivalent of the existing linear
+// untarOne scan, used as a fallback when no eStargz TOC is present. Unlike
+// untarOne it gunzips the stream first, since UntarOneAt's contract is a
+// tar.gz archive rather than a bare tar stream.
+func untarOneAt(name string, dst io.Writer, ra io.ReaderAt, size int64) error {
+	gz, err := gzip.NewReader(io.NewSectionReader(ra, 0, size))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tarball := tar.NewReader(gz)
+	for {
+		hdr, err := tarball.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name != name {
+			continue
+		}
+		_, err = io.Copy(dst, tarball)
+		return err
+	}
+	return fmt.Errorf("untarOneAt: %q not found", name)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// estargzExtraField builds the RFC1952 FEXTRA subfield real eStargz
+// archives use: ID "SG", a little-endian length, then the offset payload.
+func estargzExtraField(tocOffset int64) []byte {
+	payload := []byte(fmt.Sprintf("%016xSTARGZ", tocOffset))
+	extra := make([]byte, 4+len(payload))
+	extra[0], extra[1] = 'S', 'G'
+	binary.LittleEndian.PutUint16(extra[2:4], uint16(len(payload)))
+	copy(extra[4:], payload)
+	return extra
+}
+
+func TestParseEstargzExtraRoundTrip(t *testing.T) {
+	offset := int64(0x1a2b)
+	extra := estargzExtraField(offset)
+
+	got, ok := parseEstargzExtra(extra)
+	if !ok {
+		t.Fatal("expected parseEstargzExtra to recognize a real eStargz extra field")
+	}
+	if got != offset {
+		t.Fatalf("got offset %x, want %x", got, offset)
+	}
+}
+
+func TestParseEstargzExtraRejectsGarbage(t *testing.T) {
+	if _, ok := parseEstargzExtra([]byte("not an estargz footer")); ok {
+		t.Fatal("expected parseEstargzExtra to reject non-eStargz extra data")
+	}
+}
+
+// buildEstargzFixture assembles a minimal archive laid out the way
+// UntarOneAt expects: one independently-gzipped file entry, followed by a
+// gzipped JSON TOC, followed by a footer whose extra field points back at
+// the TOC using the real eStargz "<16 hex><<STARGZ>" layout.
+func buildEstargzFixture(t *testing.T, name, content string) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+
+	entryOffset := int64(buf.Len())
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tocOffset := int64(buf.Len())
+	toc := estargzTOC{
+		Version: 1,
+		Entries: []estargzTOCEntry{
+			{Name: name, Type: "reg", Offset: entryOffset, Size: int64(len(content))},
+		},
+	}
+	tocBytes, err := json.Marshal(toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tocGw := gzip.NewWriter(buf)
+	if _, err := tocGw.Write(tocBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := tocGw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The footer is written byte by byte so it is exactly estargzFooterSize
+	// long: a gzip header with FEXTRA set, the extra field, an empty final
+	// stored deflate block, and a zero CRC-32/size trailer. gzip.Writer's
+	// encoding of an empty NoCompression stream is shorter than that on
+	// some Go releases.
+	extra := estargzExtraField(tocOffset)
+	footer := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff}
+	footer = append(footer, byte(len(extra)), byte(len(extra)>>8))
+	footer = append(footer, extra...)
+	footer = append(footer, 0x01, 0x00, 0x00, 0xff, 0xff)
+	footer = append(footer, make([]byte, 8)...)
+	if len(footer) != estargzFooterSize {
+		t.Fatalf("footer is %d bytes, want %d", len(footer), estargzFooterSize)
+	}
+	buf.Write(footer)
+
+	return buf
+}
+
+func TestUntarOneAtUsesEstargzTOC(t *testing.T) {
+	content := "hello from the TOC"
+	buf := buildEstargzFixture(t, "hello.txt", content)
+
+	var out bytes.Buffer
+	ra := bytes.NewReader(buf.Bytes())
+	if err := UntarOneAt("hello.txt", &out, ra, int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != content {
+		t.Fatalf("got %q, want %q", out.String(), content)
+	}
+}
+
+func TestUntarOneAtFallsBackWithoutTOC(t *testing.T) {
+	content := "a plain tar.gz with no TOC footer"
+	buf := newTarGzWithFile(t, "plain.txt", content)
+
+	var out bytes.Buffer
+	ra := bytes.NewReader(buf.Bytes())
+	if err := UntarOneAt("plain.txt", &out, ra, int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != content {
+		t.Fatalf("got %q, want %q", out.String(), content)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ChecksumMismatchError is returned by FetchTarballVerified when the
+// downloaded content does not match the expected digest.
+type ChecksumMismatchError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch fetching %s: expected %s, got %s", e.URL, e.Expected, e.Actual)
+}
+
+// FetchOptions configures FetchTarballVerified.
+type FetchOptions struct {
+	// SHA256 or SHA512 is the expected hex-encoded digest of the full
+	// response body. At most one should be set.
+	SHA256 string
+	SHA512 string
+
+	// ResumePath, if set, is a path on disk holding a partial download
+	// from a previous attempt. FetchTarballVerified will try to resume
+	// it with a Range request before falling back to a full fetch.
+	ResumePath string
+
+	// MaxRetries is the number of additional attempts made after a 5xx
+	// response or transient network error. Defaults to 3 when zero.
+	MaxRetries int
+
+	// Progress, when non-nil, is invoked after every chunk read with the
+	// number of bytes read so far and the total size reported by the
+	// server (0 if unknown).
+	Progress func(bytesRead, totalBytes int64)
+}
+
+// FetchTarballVerified fetches url like fetchTarball, but additionally
+// validates a sha256/sha512 digest, resumes a partial ResumePath download
+// via HTTP Range requests, retries transient failures with exponential
+// backoff, and reports progress through opts.Progress.
+func FetchTarballVerified(url string, opts FetchOptions) (*http.Response, error) {
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 3
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoffDelay(attempt))
+		}
+
+		resp, err := fetchOnce(url, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("Bad status code fetching tarball: %s (%s)", url, resp.Status)
+			continue
+		}
+		if resp.StatusCode != 200 && resp.StatusCode != 206 {
+			return resp, fmt.Errorf("Bad status code fetching tarball: %s", url)
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// fetchOnce issues a single request, resuming from opts.ResumePath when
+// possible, and wraps the body so the checksum and progress are tracked as
+// it is read.
+func fetchOnce(url string, opts FetchOptions) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resumeFrom int64
+	var resumeFile *os.File
+	metaPath := opts.ResumePath + ".meta"
+	if opts.ResumePath != "" {
+		if fi, err := os.Stat(opts.ResumePath); err == nil {
+			if etag, lastMod, ok := readResumeMeta(metaPath); ok {
+				resumeFrom = fi.Size()
+				req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+				if etag != "" {
+					req.Header.Set("If-Range", etag)
+				} else if lastMod != "" {
+					req.Header.Set("If-Range", lastMod)
+				}
+			}
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resumeFrom > 0 && resp.StatusCode != http.StatusPartialContent {
+		// Server rejected the resume (If-Range didn't match, or it just
+		// ignored Range); start over from scratch.
+		resumeFrom = 0
+	}
+
+	if opts.ResumePath != "" {
+		flags := os.O_CREATE | os.O_WRONLY
+		if resumeFrom > 0 {
+			flags |= os.O_APPEND
+		} else {
+			flags |= os.O_TRUNC
+		}
+		resumeFile, err = os.OpenFile(opts.ResumePath, flags, 0644)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		writeResumeMeta(metaPath, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	}
+
+	total := resp.ContentLength
+	if total < 0 {
+		total = 0
+	}
+	if total > 0 && resumeFrom > 0 {
+		total += resumeFrom
+	}
+
+	h, wantHex := checksumFor(opts)
+	if h != nil && resumeFrom > 0 {
+		if err := primeHashFromFile(h, opts.ResumePath, resumeFrom); err != nil {
+			resp.Body.Close()
+			resumeFile.Close()
+			return nil, err
+		}
+	}
+
+	counter := NewCounterReader(resp.Body)
+	var r io.Reader = counter
+	if h != nil {
+		r = io.TeeReader(counter, h)
+	}
+
+	resp.Body = &verifyingReadCloser{
+		r:          r,
+		underlying: resp.Body,
+		resumeFile: resumeFile,
+		resumeFrom: resumeFrom,
+		counter:    counter,
+		total:      total,
+		progress:   opts.Progress,
+		hash:       h,
+		wantHex:    wantHex,
+		url:        url,
+	}
+	return resp, nil
+}
+
+// checksumFor returns the hash.Hash and expected hex digest to validate
+// against, based on whichever of SHA256/SHA512 is set in opts.
+func checksumFor(opts FetchOptions) (hash.Hash, string) {
+	if opts.SHA256 != "" {
+		return sha256.New(), opts.SHA256
+	}
+	if opts.SHA512 != "" {
+		return sha512.New(), opts.SHA512
+	}
+	return nil, ""
+}
+
+// primeHashFromFile seeds h with the n bytes already on disk at path, so a
+// resumed download's final digest covers the whole file (the bytes kept
+// from a previous attempt plus the newly fetched suffix), not just the
+// suffix fetched this time.
+func primeHashFromFile(h hash.Hash, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(h, f, n)
+	return err
+}
+
+// verifyingReadCloser streams the response body, optionally tees a copy of
+// a partial download to disk for resumption, reports progress, and
+// validates the final digest once the body is fully read.
+type verifyingReadCloser struct {
+	r          io.Reader
+	underlying io.ReadCloser
+	resumeFile *os.File
+	resumeFrom int64
+	counter    *CounterReader
+	total      int64
+	progress   func(bytesRead, totalBytes int64)
+	hash       hash.Hash
+	wantHex    string
+	url        string
+	done       bool
+}
+
+func (v *verifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n > 0 {
+		if v.resumeFile != nil {
+			if _, werr := v.resumeFile.Write(p[:n]); werr != nil {
+				return n, werr
+			}
+		}
+		if v.progress != nil {
+			v.progress(v.resumeFrom+v.counter.Count(), v.total)
+		}
+	}
+	if err == io.EOF && !v.done {
+		v.done = true
+		if v.hash != nil {
+			got := fmt.Sprintf("%x", v.hash.Sum(nil))
+			if got != v.wantHex {
+				return n, &ChecksumMismatchError{URL: v.url, Expected: v.wantHex, Actual: got}
+			}
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReadCloser) Close() error {
+	if v.resumeFile != nil {
+		v.resumeFile.Close()
+	}
+	return v.underlying.Close()
+}
+
+// readResumeMeta reads the ETag/Last-Modified recorded alongside a partial
+// download, returning ok=false if no metadata is available.
+func readResumeMeta(metaPath string) (etag, lastModified string, ok bool) {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return "", "", false
+	}
+	lines := splitLines(string(data))
+	if len(lines) < 2 {
+		return "", "", false
+	}
+	return lines[0], lines[1], true
+}
+
+// writeResumeMeta persists the ETag/Last-Modified of a response so a later
+// resume attempt can validate the partial file still matches via If-Range.
+func writeResumeMeta(metaPath, etag, lastModified string) {
+	os.WriteFile(metaPath, []byte(etag+"\n"+lastModified+"\n"), 0644)
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			lines = append(lines, s[start:i])
+			start = i + 1
+		}
+	}
+	return lines
+}
+
+// backoffDelay returns an exponential backoff duration (with jitter) for
+// the given retry attempt, starting at roughly half a second.
+func backoffDelay(attempt int) time.Duration {
+	base := 500 * time.Millisecond
+	delay := base << uint(attempt-1)
+	jitter := time.Duration(rand.Int63n(int64(base)))
+	return delay + jitter
+}
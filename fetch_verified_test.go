@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFetchTarballVerifiedChecksumMismatch(t *testing.T) {
+	body := "hello world"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	resp, err := FetchTarballVerified(srv.URL, FetchOptions{SHA256: "0000000000000000000000000000000000000000000000000000000000000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	_, err = ioutil.ReadAll(resp.Body)
+	if _, ok := err.(*ChecksumMismatchError); !ok {
+		t.Fatalf("got err %v (%T), want *ChecksumMismatchError", err, err)
+	}
+}
+
+func TestFetchTarballVerifiedChecksumMatch(t *testing.T) {
+	body := "hello world"
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	resp, err := FetchTarballVerified(srv.URL, FetchOptions{SHA256: sum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Fatalf("got %q, want %q", got, body)
+	}
+}
+
+// TestFetchTarballVerifiedWritesResumePath guards against FetchTarballVerified
+// accepting a ResumePath that nothing ever writes to, which would make the
+// resume branch permanently unreachable.
+func TestFetchTarballVerifiedWritesResumePath(t *testing.T) {
+	body := "hello world"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "wercker-fetch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resumePath := filepath.Join(dir, "download.part")
+
+	resp, err := FetchTarballVerified(srv.URL, FetchOptions{ResumePath: resumePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	got, err := ioutil.ReadFile(resumePath)
+	if err != nil {
+		t.Fatalf("ResumePath was never written: %v", err)
+	}
+	if string(got) != body {
+		t.Fatalf("got %q on disk, want %q", got, body)
+	}
+}
+
+func TestFetchTarballVerifiedResumesPartialDownload(t *testing.T) {
+	body := "hello world, this is the full body"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHdr := r.Header.Get("Range")
+		if rangeHdr == "" {
+			w.Write([]byte(body))
+			return
+		}
+		var start int
+		fmt.Sscanf(strings.TrimPrefix(rangeHdr, "bytes="), "%d-", &start)
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-/"+strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(body[start:]))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "wercker-fetch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resumePath := filepath.Join(dir, "download.part")
+
+	// Seed a partial download and its resume metadata as if a previous
+	// attempt had been interrupted.
+	if err := ioutil.WriteFile(resumePath, []byte(body[:5]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(resumePath+".meta", []byte("\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := FetchTarballVerified(srv.URL, FetchOptions{ResumePath: resumePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	rest, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != body[5:] {
+		t.Fatalf("got body %q, want the remainder %q", rest, body[5:])
+	}
+
+	got, err := ioutil.ReadFile(resumePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Fatalf("got %q on disk after resume, want full body %q", got, body)
+	}
+}
+
+// TestFetchTarballVerifiedResumeChecksumsFullBody guards against hashing only
+// the newly-fetched suffix of a resumed download: the expected digest is for
+// the whole file, so the bytes already on disk must be folded into the hash
+// too.
+func TestFetchTarballVerifiedResumeChecksumsFullBody(t *testing.T) {
+	body := "hello world, this is the full body"
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHdr := r.Header.Get("Range")
+		if rangeHdr == "" {
+			w.Write([]byte(body))
+			return
+		}
+		var start int
+		fmt.Sscanf(strings.TrimPrefix(rangeHdr, "bytes="), "%d-", &start)
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-/"+strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(body[start:]))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "wercker-fetch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resumePath := filepath.Join(dir, "download.part")
+
+	if err := ioutil.WriteFile(resumePath, []byte(body[:5]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(resumePath+".meta", []byte("\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := FetchTarballVerified(srv.URL, FetchOptions{ResumePath: resumePath, SHA256: sum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatalf("expected the resumed download to pass checksum verification, got: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ProgressDetail carries the current/total byte counts for a progress
+// event, mirroring Docker's jsonmessage.JSONProgress.
+type ProgressDetail struct {
+	Current int64 `json:"current"`
+	Total   int64 `json:"total"`
+}
+
+// ErrorDetail carries a failure message for a progress event.
+type ErrorDetail struct {
+	Message string `json:"message"`
+}
+
+// ProgressEvent is a single newline-delimited JSON message describing the
+// progress of one fetch/extract operation, keyed by ID so multiple
+// concurrent operations can be demultiplexed by a consumer.
+type ProgressEvent struct {
+	ID             string          `json:"id"`
+	Status         string          `json:"status"`
+	ProgressDetail *ProgressDetail `json:"progressDetail,omitempty"`
+	ErrorDetail    *ErrorDetail    `json:"errorDetail,omitempty"`
+}
+
+// ProgressWriter fans progress events for possibly many concurrently
+// running operations (e.g. parallel step fetches) out to subscribers, in
+// the same spirit as Docker's jsonmessage stream used by `docker pull`.
+type ProgressWriter struct {
+	l       sync.Mutex
+	writers []func(ProgressEvent)
+}
+
+// NewProgressWriter returns an empty ProgressWriter ready to accept
+// Publish calls and writer subscriptions.
+func NewProgressWriter() *ProgressWriter {
+	return &ProgressWriter{}
+}
+
+// Publish emits ev to every subscribed writer.
+func (p *ProgressWriter) Publish(ev ProgressEvent) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	for _, w := range p.writers {
+		w(ev)
+	}
+}
+
+// Progress returns a callback suitable for FetchOptions.Progress/similar
+// byte-counting hooks, which publishes a ProgressEvent under id each time
+// it's invoked.
+func (p *ProgressWriter) Progress(id string) func(current, total int64) {
+	return func(current, total int64) {
+		p.Publish(ProgressEvent{
+			ID:     id,
+			Status: "Downloading",
+			ProgressDetail: &ProgressDetail{
+				Current: current,
+				Total:   total,
+			},
+		})
+	}
+}
+
+// Error publishes a terminal error event for id.
+func (p *ProgressWriter) Error(id string, err error) {
+	p.Publish(ProgressEvent{
+		ID:          id,
+		Status:      "Error",
+		ErrorDetail: &ErrorDetail{Message: err.Error()},
+	})
+}
+
+// PlainText subscribes a human-readable writer: one line per event,
+// overwriting the previous line for the same id the way docker pull does
+// isn't attempted here, so every update is simply appended.
+func (p *ProgressWriter) PlainText(w io.Writer) {
+	p.subscribe(func(ev ProgressEvent) {
+		if ev.ErrorDetail != nil {
+			fmt.Fprintf(w, "%s: %s\n", ev.ID, ev.ErrorDetail.Message)
+			return
+		}
+		if ev.ProgressDetail != nil && ev.ProgressDetail.Total > 0 {
+			fmt.Fprintf(w, "%s: %s %d/%d\n", ev.ID, ev.Status, ev.ProgressDetail.Current, ev.ProgressDetail.Total)
+			return
+		}
+		fmt.Fprintf(w, "%s: %s\n", ev.ID, ev.Status)
+	})
+}
+
+// JSON subscribes a writer that emits each event as a line of
+// newline-delimited JSON, for CI consumers to parse.
+func (p *ProgressWriter) JSON(w io.Writer) {
+	enc := json.NewEncoder(w)
+	p.subscribe(func(ev ProgressEvent) {
+		enc.Encode(ev)
+	})
+}
+
+func (p *ProgressWriter) subscribe(w func(ProgressEvent)) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.writers = append(p.writers, w)
+}
+
+// FetchTarballWithProgress wraps fetchTarball, publishing download
+// progress under id to pw as the body is read, using Content-Length as
+// the total when the server reports one.
+func FetchTarballWithProgress(url, id string, pw *ProgressWriter) (*http.Response, error) {
+	resp, err := fetchTarball(url)
+	if err != nil {
+		if pw != nil {
+			pw.Error(id, err)
+		}
+		return resp, err
+	}
+	if pw == nil {
+		return resp, nil
+	}
+
+	pw.Publish(ProgressEvent{ID: id, Status: "Downloading"})
+	counter := NewCounterReader(resp.Body)
+	total := resp.ContentLength
+	resp.Body = &progressReadCloser{
+		r:          counter,
+		underlying: resp.Body,
+		onRead: func() {
+			pw.Progress(id)(counter.Count(), total)
+		},
+	}
+	return resp, nil
+}
+
+// progressReadCloser invokes onRead after every non-empty Read, then
+// delegates Close to the wrapped body.
+type progressReadCloser struct {
+	r          io.Reader
+	underlying io.ReadCloser
+	onRead     func()
+}
+
+func (p *progressReadCloser) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if n > 0 {
+		p.onRead()
+	}
+	return n, err
+}
+
+func (p *progressReadCloser) Close() error {
+	return p.underlying.Close()
+}
+
+// UntargzipWithProgress wraps SafeUntargzip, publishing a progress event
+// after each chunk written while a regular file entry is copied: the entry
+// name as id, bytes copied so far as current, and the header size as total. It goes through the same
+// hardened, safe-path-checked extraction as SafeUntargzip rather than
+// re-walking the tar stream itself.
+func UntargzipWithProgress(path string, r io.Reader, pw *ProgressWriter) error {
+	opts := &ExtractOptions{}
+	if pw != nil {
+		opts.Progress = func(name string, current, total int64) {
+			pw.Publish(ProgressEvent{ID: name, Status: "Extracting", ProgressDetail: &ProgressDetail{Current: current, Total: total}})
+		}
+	}
+
+	err := SafeUntargzip(path, r, opts)
+	if err != nil && pw != nil {
+		pw.Error("", err)
+	}
+	return err
+}
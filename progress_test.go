@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTarGzWithFile(t *testing.T, name, body string) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// TestUntargzipWithProgressRejectsZipSlip guards against UntargzipWithProgress
+// reimplementing its own tar walk and reopening the path traversal hole
+// SafeUntargzip closes; it must go through the same hardened extraction.
+func TestUntargzipWithProgressRejectsZipSlip(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := newTarGzWithFile(t, "../outside/pwned.txt", "pwned")
+	pw := NewProgressWriter()
+	if err := UntargzipWithProgress(dst, buf, pw); err == nil {
+		t.Fatal("expected an error extracting an entry that escapes dst")
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "outside", "pwned.txt")); err == nil {
+		t.Fatal("UntargzipWithProgress wrote a file outside dst")
+	}
+}
+
+func TestUntargzipWithProgressReportsEvents(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := newTarGzWithFile(t, "hello.txt", "hello world")
+	pw := NewProgressWriter()
+
+	var events []ProgressEvent
+	pw.subscribe(func(ev ProgressEvent) {
+		events = append(events, ev)
+	})
+
+	if err := UntargzipWithProgress(dst, buf, pw); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Fatal("expected at least one progress event")
+	}
+	last := events[len(events)-1]
+	if last.ID != "hello.txt" || last.ProgressDetail == nil || last.ProgressDetail.Current != int64(len("hello world")) {
+		t.Fatalf("unexpected final progress event: %+v", last)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExtractOptions controls the behavior of SafeUntargzip.
+type ExtractOptions struct {
+	// ChownUIDMap, when non-nil, remaps the uid recorded in the tar header
+	// before it is applied to the extracted file. The recorded gid is
+	// applied unchanged.
+	ChownUIDMap map[int]int
+
+	// PreserveTimes applies the mtime recorded in the tar header to the
+	// extracted file instead of leaving it at the time of extraction.
+	PreserveTimes bool
+
+	// MaxSize is the maximum total number of bytes that may be written
+	// while extracting the archive. Zero means unlimited.
+	MaxSize int64
+
+	// MaxFiles is the maximum number of entries that may be extracted
+	// from the archive. Zero means unlimited.
+	MaxFiles int
+
+	// Progress, when non-nil, is invoked as each regular file entry is
+	// copied out of the archive, with its (possibly git-archive-stripped)
+	// name, the bytes copied so far for that entry, and its declared size.
+	Progress func(name string, current, total int64)
+}
+
+// safeJoin joins dst and name, then verifies the result is still rooted at
+// dst. This guards against Zip-Slip style path traversal via ".." segments
+// or absolute paths baked into a tar header.
+func safeJoin(dst, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("untargzip: refusing absolute entry name %q", name)
+	}
+
+	fpath := filepath.Join(dst, name)
+	rel, err := filepath.Rel(dst, fpath)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("untargzip: entry %q escapes destination %q", name, dst)
+	}
+	return fpath, nil
+}
+
+// stripGitArchivePrefix strips the leading repo-name path segment `git
+// archive` adds to every entry, so a git-archive tarball extracts the same
+// way a plain tarball of its contents would.
+func stripGitArchivePrefix(name string) string {
+	parts := strings.Split(name, "/")
+	return strings.Join(parts[1:], "/")
+}
+
+// SafeUntargzip untars and gunzips r into dst, hardening untargzip against
+// path traversal (Zip-Slip) and adding support for symlinks and hardlinks.
+// A call untargzip(path, r) can be replaced by SafeUntargzip(path, r, nil).
+func SafeUntargzip(dst string, r io.Reader, opts *ExtractOptions) error {
+	ungzipped, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer ungzipped.Close()
+
+	return safeUntarStream(dst, tar.NewReader(ungzipped), opts)
+}
+
+// safeUntarStream walks an already-decompressed tar stream applying the
+// safe-path/symlink/hardlink rules, size limits, and metadata handling
+// shared by every archive format Extract supports.
+func safeUntarStream(dst string, tarball *tar.Reader, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+
+	// We have to treat things differently for git-archives
+	isGitArchive := false
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	var written int64
+	var fileCount int
+	for {
+		hdr, err := tarball.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Skip the base dir
+		if hdr.Name == "./" {
+			continue
+		}
+
+		name := hdr.Name
+		if name == "pax_global_header" {
+			isGitArchive = true
+			continue
+		}
+		if isGitArchive {
+			name = stripGitArchivePrefix(name)
+		}
+
+		fpath, err := safeJoin(dst, name)
+		if err != nil {
+			return err
+		}
+
+		fileCount++
+		if opts.MaxFiles > 0 && fileCount > opts.MaxFiles {
+			return fmt.Errorf("untargzip: archive exceeds MaxFiles (%d)", opts.MaxFiles)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(fpath, hdr.FileInfo().Mode()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := extractSymlink(dst, fpath, hdr.Linkname); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeLink:
+			linkname := hdr.Linkname
+			if isGitArchive {
+				linkname = stripGitArchivePrefix(linkname)
+			}
+			target, err := safeJoin(dst, linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(target, fpath); err != nil {
+				return err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			// Device nodes and FIFOs require CGO/root to create portably;
+			// we don't need them for step/build tarballs, so skip them.
+			continue
+		default:
+			if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+				return err
+			}
+			written, err = extractFile(fpath, name, tarball, hdr, opts, written)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := applyMetadata(fpath, hdr, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractSymlink creates a symlink at fpath pointing at linkname, rejecting
+// any link whose resolved target would escape dst.
+func extractSymlink(dst, fpath, linkname string) error {
+	resolved := linkname
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(fpath), resolved)
+	}
+	rel, err := filepath.Rel(dst, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("untargzip: symlink %q -> %q escapes destination %q", fpath, linkname, dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		return err
+	}
+	os.Remove(fpath)
+	return os.Symlink(linkname, fpath)
+}
+
+// extractFile streams a single regular file entry out of tarball into
+// fpath, enforcing opts.MaxSize across the whole archive and reporting
+// opts.Progress for this entry as it's copied.
+func extractFile(fpath, name string, tarball *tar.Reader, hdr *tar.Header, opts *ExtractOptions, written int64) (int64, error) {
+	file, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode())
+	if err != nil {
+		return written, err
+	}
+	defer file.Close()
+
+	src := io.Reader(tarball)
+	if opts.MaxSize > 0 {
+		src = io.LimitReader(tarball, opts.MaxSize-written+1)
+	}
+
+	var dst io.Writer = file
+	if opts.Progress != nil {
+		dst = &progressFileWriter{w: file, name: name, total: hdr.Size, progress: opts.Progress}
+	}
+
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return written, err
+	}
+	written += n
+	if opts.MaxSize > 0 && written > opts.MaxSize {
+		return written, fmt.Errorf("untargzip: archive exceeds MaxSize (%d bytes)", opts.MaxSize)
+	}
+	return written, nil
+}
+
+// progressFileWriter wraps a file being extracted, reporting cumulative
+// bytes written for its entry through progress after each chunk.
+type progressFileWriter struct {
+	w        io.Writer
+	name     string
+	total    int64
+	current  int64
+	progress func(name string, current, total int64)
+}
+
+func (p *progressFileWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.current += int64(n)
+	p.progress(p.name, p.current, p.total)
+	return n, err
+}
+
+// applyMetadata restores ownership and mtime on fpath when requested by
+// opts. Callers do not apply it to symlinks; extractSymlink sets neither
+// ownership nor mtime on the links it creates.
+func applyMetadata(fpath string, hdr *tar.Header, opts *ExtractOptions) error {
+	if opts.ChownUIDMap != nil {
+		uid, gid := hdr.Uid, hdr.Gid
+		if mapped, ok := opts.ChownUIDMap[uid]; ok {
+			uid = mapped
+		}
+		if err := os.Chown(fpath, uid, gid); err != nil {
+			return err
+		}
+	}
+	if opts.PreserveTimes {
+		mtime := hdr.ModTime
+		if mtime.IsZero() {
+			mtime = time.Now()
+		}
+		if err := os.Chtimes(fpath, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
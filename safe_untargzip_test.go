@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTarHeader(t *testing.T, tw *tar.Writer, hdr *tar.Header, body string) {
+	t.Helper()
+	hdr.Size = int64(len(body))
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSafeUntargzipRejectsPathTraversal(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{Name: "../outside/pwned.txt", Mode: 0644}, "pwned")
+	tw.Close()
+	gw.Close()
+
+	if err := SafeUntargzip(dst, buf, nil); err == nil {
+		t.Fatal("expected an error for an entry escaping dst via ..")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "outside", "pwned.txt")); err == nil {
+		t.Fatal("SafeUntargzip wrote a file outside dst")
+	}
+}
+
+func TestSafeUntargzipRejectsAbsolutePath(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{Name: "/etc/passwd", Mode: 0644}, "pwned")
+	tw.Close()
+	gw.Close()
+
+	if err := SafeUntargzip(dst, buf, nil); err == nil {
+		t.Fatal("expected an error for an absolute entry name")
+	}
+}
+
+func TestSafeUntargzipRejectsSymlinkEscape(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{
+		Name:     "link",
+		Typeflag: tar.TypeSymlink,
+		Linkname: "../../etc/passwd",
+		Mode:     0777,
+	}, "")
+	tw.Close()
+	gw.Close()
+
+	if err := SafeUntargzip(dst, buf, nil); err == nil {
+		t.Fatal("expected an error for a symlink target escaping dst")
+	}
+}
+
+func TestSafeUntargzipExtractsValidSymlink(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{Name: "target.txt", Mode: 0644}, "hi")
+	writeTarHeader(t, tw, &tar.Header{
+		Name:     "link",
+		Typeflag: tar.TypeSymlink,
+		Linkname: "target.txt",
+		Mode:     0777,
+	}, "")
+	tw.Close()
+	gw.Close()
+
+	if err := SafeUntargzip(dst, buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.Readlink(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "target.txt" {
+		t.Fatalf("got symlink target %q, want %q", got, "target.txt")
+	}
+}
+
+func TestSafeUntargzipExtractsValidHardlink(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{Name: "target.txt", Mode: 0644}, "hi")
+	writeTarHeader(t, tw, &tar.Header{
+		Name:     "link",
+		Typeflag: tar.TypeLink,
+		Linkname: "target.txt",
+	}, "")
+	tw.Close()
+	gw.Close()
+
+	if err := SafeUntargzip(dst, buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hi" {
+		t.Fatalf("got %q, want %q", got, "hi")
+	}
+}
+
+func TestSafeUntargzipRejectsHardlinkEscape(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{
+		Name:     "link",
+		Typeflag: tar.TypeLink,
+		Linkname: "../../etc/passwd",
+	}, "")
+	tw.Close()
+	gw.Close()
+
+	if err := SafeUntargzip(dst, buf, nil); err == nil {
+		t.Fatal("expected an error for a hardlink target escaping dst")
+	}
+}
+
+// TestSafeUntargzipGitArchiveHardlink guards against a regression where the
+// git-archive prefix strip applied to hdr.Name was not also applied to
+// hdr.Linkname, leaving a hardlink's target pointing at the unstripped path
+// and failing to resolve against the already-stripped extracted file.
+func TestSafeUntargzipGitArchiveHardlink(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader}, "")
+	writeTarHeader(t, tw, &tar.Header{Name: "myrepo/target.txt", Mode: 0644}, "hi")
+	writeTarHeader(t, tw, &tar.Header{
+		Name:     "myrepo/link",
+		Typeflag: tar.TypeLink,
+		Linkname: "myrepo/target.txt",
+	}, "")
+	tw.Close()
+	gw.Close()
+
+	if err := SafeUntargzip(dst, buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hi" {
+		t.Fatalf("got %q, want %q", got, "hi")
+	}
+}
+
+func TestSafeUntargzipEnforcesMaxFiles(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{Name: "one.txt", Mode: 0644}, "1")
+	writeTarHeader(t, tw, &tar.Header{Name: "two.txt", Mode: 0644}, "2")
+	tw.Close()
+	gw.Close()
+
+	err = SafeUntargzip(dst, buf, &ExtractOptions{MaxFiles: 1})
+	if err == nil {
+		t.Fatal("expected an error for an archive exceeding MaxFiles")
+	}
+}
+
+func TestSafeUntargzipEnforcesMaxSize(t *testing.T) {
+	dst, err := ioutil.TempDir("", "wercker-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	writeTarHeader(t, tw, &tar.Header{Name: "big.txt", Mode: 0644}, "0123456789")
+	tw.Close()
+	gw.Close()
+
+	err = SafeUntargzip(dst, buf, &ExtractOptions{MaxSize: 4})
+	if err == nil {
+		t.Fatal("expected an error for an archive exceeding MaxSize")
+	}
+}